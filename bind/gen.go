@@ -7,10 +7,15 @@ package bind
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/token"
 	"go/types"
+	"hash/fnv"
 	"io"
 	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/gcexportdata"
 )
 
 type (
@@ -40,8 +45,35 @@ const (
 	// modeReturned are for values that are returned to the
 	// caller of a function. Returned values are always copied.
 	modeReturned
+	// modePinned are for []byte arguments marked with a //gobind:pin
+	// directive. They are passed as a pinned (ptr,len,cap) triple
+	// instead of being copied; the caller must not mutate the slice
+	// while the foreign wrapper holds it.
+	modePinned
 )
 
+// pinDirective is the //gobind: comment recognized on a []byte parameter
+// to request modePinned instead of the default copying behavior.
+const pinDirective = "gobind:pin"
+
+// hasPinDirective reports whether doc or comment (the AST comment groups
+// attached to a parameter field) carry the //gobind:pin directive.
+func hasPinDirective(doc, comment *ast.CommentGroup) bool {
+	return hasDirective(doc, pinDirective) || hasDirective(comment, pinDirective)
+}
+
+func hasDirective(cg *ast.CommentGroup, directive string) bool {
+	if cg == nil {
+		return false
+	}
+	for _, c := range cg.List {
+		if strings.TrimSpace(strings.TrimPrefix(c.Text, "//")) == directive {
+			return true
+		}
+	}
+	return false
+}
+
 func (m varMode) copyString() bool {
 	return m == modeReturned
 }
@@ -61,11 +93,119 @@ func (list ErrorList) Error() string {
 	return buf.String()
 }
 
+// Prefixer resolves the pkgPrefix used to disambiguate generated function
+// and type names across every package bound in a single bind.Bind
+// invocation. A package keeps its plain leaf name as a prefix unless a
+// second, distinct import path shares that leaf name, in which case both
+// are (deterministically) disambiguated by a short hash of their full
+// import path, so regenerating the same set of packages always produces
+// the same names regardless of bind order.
+type Prefixer struct {
+	prefix map[string]string // import path -> resolved prefix
+}
+
+// NewPrefixer returns a Prefixer resolving prefixes for pkgs, the complete
+// set of packages bound together in one bind.Bind invocation.
+func NewPrefixer(pkgs []*types.Package) *Prefixer {
+	byLeaf := make(map[string][]string) // leaf name -> import paths
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if seen[pkg.Path()] {
+			continue
+		}
+		seen[pkg.Path()] = true
+		leaf := pkg.Name()
+		byLeaf[leaf] = append(byLeaf[leaf], pkg.Path())
+	}
+	p := &Prefixer{prefix: make(map[string]string)}
+	for leaf, paths := range byLeaf {
+		if len(paths) == 1 {
+			p.prefix[paths[0]] = leaf
+			continue
+		}
+		for _, path := range paths {
+			p.prefix[path] = leaf + "_" + pathHash(path)
+		}
+	}
+	return p
+}
+
+// Prefix returns the prefix to use for pkg's generated names.
+func (p *Prefixer) Prefix(pkg *types.Package) string {
+	if prefix, ok := p.prefix[pkg.Path()]; ok {
+		return prefix
+	}
+	// pkg was not part of the set NewPrefixer was built from.
+	return pkg.Name()
+}
+
+// pathHash returns the first 6 hex characters of the FNV-64 hash of path.
+func pathHash(path string) string {
+	h := fnv.New64()
+	io.WriteString(h, path)
+	return fmt.Sprintf("%016x", h.Sum64())[:6]
+}
+
+// NewGenerators constructs one generator per package in pkgs, the
+// complete set of packages bound together in a single bind.Bind
+// invocation, sharing one Prefixer across all of them so that pkgPrefix
+// stays collision-free whenever two of the packages share a leaf name.
+func NewGenerators(fset *token.FileSet, pkgs []*types.Package) []*generator {
+	prefixer := NewPrefixer(pkgs)
+	gens := make([]*generator, len(pkgs))
+	for i, pkg := range pkgs {
+		g := &generator{fset: fset, pkg: pkg, prefixer: prefixer}
+		g.init()
+		gens[i] = g
+	}
+	return gens
+}
+
+// NewExportDataImporter returns a types.Importer resolving packages from
+// precompiled export data, for use with NewFromExportData.
+func NewExportDataImporter(fset *token.FileSet) types.Importer {
+	return gcexportdata.NewImporter(fset, make(map[string]*types.Package))
+}
+
+// NewFromExportData constructs a generator for pkgPath using only its
+// compiled export data, resolved through imp (typically built with
+// NewExportDataImporter), instead of type-checking pkgPath from source.
+//
+// goos and goarch name the mobile target the bindings are for, which is
+// usually not the host the generator runs on, so g.sizes reflects the
+// target's int width rather than runtime.GOARCH's.
+func NewFromExportData(fset *token.FileSet, imp types.Importer, pkgPath, goos, goarch string) (*generator, error) {
+	pkg, err := imp.Import(pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("bind: loading export data for %q: %v", pkgPath, err)
+	}
+	sizes := types.SizesFor("gc", goarch)
+	if sizes == nil {
+		return nil, fmt.Errorf("bind: unsupported target %s/%s", goos, goarch)
+	}
+	g := &generator{
+		fset:  fset,
+		pkg:   pkg,
+		sizes: sizes,
+	}
+	g.init()
+	return g, nil
+}
+
+// generator emits the Cgo, Java and Objective-C glue for the exported API
+// of pkg. pkg may be type-checked from source, or reconstituted from
+// export data via NewFromExportData, in which case sizes is required.
 type generator struct {
 	*printer
 	fset *token.FileSet
 	pkg  *types.Package
-	err  ErrorList
+	// prefixer resolves pkgPrefix across a multi-package bind; nil for a
+	// single-package bind, where pkgPrefix is just the package name.
+	prefixer *Prefixer
+	// sizes is the target's type sizes; required when pkg came from
+	// export data, since there's no source AST to infer it from.
+	sizes types.Sizes
+	err   ErrorList
 
 	// fields set by init.
 	pkgName string
@@ -79,14 +219,82 @@ type generator struct {
 	interfaces []interfaceInfo
 	structs    []structInfo
 	otherNames []*types.TypeName
+
+	// info is the type-checker's record of the package's instantiation
+	// expressions, needed to find the concrete instantiations of an
+	// exported generic function. Neither NewGenerators nor
+	// NewFromExportData sets it, so it is nil unless the caller sets it.
+	info *types.Info
+
+	// instantiations holds the generic instantiations reachable from the
+	// exported API, gathered from exported type aliases that name them
+	// (e.g. "type IntBox = Box[int]"). Each is monomorphized into its own
+	// synthetic binding under a mangled, deterministic name.
+	instantiations []*types.Named
+	// funcInstantiations holds the same, for exported generic functions
+	// instantiated via an exported var (e.g. "var AddInts = Add[int]").
+	funcInstantiations []funcInstantiation
+	// curInstantiation is set while generating code for one entry of
+	// instantiations, so cgoType can resolve the *types.TypeParam values
+	// reachable from its signature.
+	curInstantiation *types.Named
+
+	// pinned holds the []byte parameters annotated with the
+	// //gobind:pin directive, populated by markPinned, which
+	// collectPinnedParams calls for each annotated parameter of the
+	// parameter lists passed to it.
+	pinned map[*types.Var]bool
+}
+
+// markPinned records that v (a []byte parameter) was declared with the
+// //gobind:pin directive in doc or comment, the comment groups attached
+// to its *ast.Field, and so must use modePinned rather than being copied.
+func (g *generator) markPinned(v *types.Var, doc, comment *ast.CommentGroup) {
+	if !hasPinDirective(doc, comment) {
+		return
+	}
+	if g.pinned == nil {
+		g.pinned = make(map[*types.Var]bool)
+	}
+	g.pinned[v] = true
+}
+
+// collectPinnedParams walks a parameter list as declared in source and
+// marks each //gobind:pin-annotated field pinned, resolving its
+// *types.Var through g.info; it does nothing when g.info is nil. It does
+// not check the field's type. init walks the package scope rather than
+// its AST and does not call it, so a parameter is only marked pinned when
+// its *ast.FuncDecl or interface method parameter list is passed here.
+func (g *generator) collectPinnedParams(params *ast.FieldList) {
+	if params == nil || g.info == nil {
+		return
+	}
+	for _, field := range params.List {
+		if !hasPinDirective(field.Doc, field.Comment) {
+			continue
+		}
+		for _, name := range field.Names {
+			if v, ok := g.info.Defs[name].(*types.Var); ok {
+				g.markPinned(v, field.Doc, field.Comment)
+			}
+		}
+	}
+}
+
+// modeOf returns the varMode to use for parameter v.
+func (g *generator) modeOf(v *types.Var) varMode {
+	if g.pinned[v] {
+		return modePinned
+	}
+	return modeTransient
 }
 
 func (g *generator) init() {
 	g.pkgName = g.pkg.Name()
-	// TODO(elias.naur): Avoid (and test) name clashes from multiple packages
-	// with the same name. Perhaps use the index from the order the package is
-	// generated.
 	g.pkgPrefix = g.pkgName
+	if g.prefixer != nil {
+		g.pkgPrefix = g.prefixer.Prefix(g.pkg)
+	}
 
 	scope := g.pkg.Scope()
 	hasExported := false
@@ -98,11 +306,42 @@ func (g *generator) init() {
 		hasExported = true
 		switch obj := obj.(type) {
 		case *types.Func:
+			if sig := obj.Type().(*types.Signature); sig.TypeParams().Len() > 0 {
+				g.collectFuncInstantiations(obj)
+				continue
+			}
 			if isCallable(obj) {
 				g.funcs = append(g.funcs, obj)
 			}
 		case *types.TypeName:
 			named := obj.Type().(*types.Named)
+			if obj.IsAlias() && named.TypeArgs().Len() > 0 {
+				// An exported alias to a concrete instantiation, e.g.
+				// "type IntBox = Box[int]", is a root: it tells us which
+				// instantiation of a generic type must be monomorphized
+				// and bound, since the generic type itself cannot be.
+				g.instantiations = append(g.instantiations, named)
+				continue
+			}
+			if named.TypeParams().Len() > 0 {
+				// A still-generic exported type with no reachable
+				// instantiation cannot be bound on its own.
+				reachable := false
+				for _, n := range scope.Names() {
+					a, ok := scope.Lookup(n).(*types.TypeName)
+					if !ok || !a.Exported() || !a.IsAlias() {
+						continue
+					}
+					if inst, ok := types.Unalias(a.Type()).(*types.Named); ok && inst.TypeArgs().Len() > 0 && inst.Origin().Obj() == obj {
+						reachable = true
+						break
+					}
+				}
+				if !reachable {
+					g.errorf("%s is generic and has no exported instantiation reachable from the package API; add an exported alias naming a concrete instantiation", obj.Name())
+				}
+				continue
+			}
 			switch t := named.Underlying().(type) {
 			case *types.Struct:
 				g.structs = append(g.structs, structInfo{obj, t})
@@ -128,6 +367,64 @@ func (g *generator) init() {
 	}
 }
 
+// collectFuncInstantiations finds the concrete instantiations of a
+// still-generic exported function, e.g. "var AddInts = Add[int]", via
+// g.info, and adds each as a root in g.funcInstantiations. Without g.info
+// (as when pkg came from export data rather than source, which carries
+// no record of which vars instantiate which generic func) a generic
+// function has no way to be bound.
+func (g *generator) collectFuncInstantiations(obj *types.Func) {
+	found := false
+	if g.info != nil {
+		for id, inst := range g.info.Instances {
+			if g.info.Uses[id] != obj {
+				continue
+			}
+			sig, ok := inst.Type.(*types.Signature)
+			if !ok {
+				continue
+			}
+			g.funcInstantiations = append(g.funcInstantiations, funcInstantiation{
+				origin: obj,
+				name:   mangledFuncName(obj, inst.TypeArgs),
+				sig:    sig,
+			})
+			found = true
+		}
+	}
+	if !found {
+		g.errorf("%s is generic and has no exported instantiation reachable from the package API; add an exported instantiation such as \"var X = %s[T]\"", obj.Name(), obj.Name())
+	}
+}
+
+// genInstantiations emits the Cgo glue for each instantiation gathered by
+// init, under its mangled name, the same way g.interfaces is emitted.
+// Like genInterfaceMethodSignature, it must be called once per output
+// pass (header, then source) by whatever walks g.interfaces for the
+// matching pass, since each produces different output for the same
+// instantiation.
+//
+// An instantiation's method signatures are read off its generic origin,
+// which still refers to the origin's *types.TypeParam values, rather
+// than off the instantiation itself (whose methods go/types has already
+// substituted) so that cgoType's *types.TypeParam case actually runs,
+// resolved against g.curInstantiation.
+func (g *generator) genInstantiations(header bool) {
+	for _, inst := range g.instantiations {
+		g.curInstantiation = inst
+		name := mangledName(inst)
+		origin, ok := inst.Origin().Underlying().(*types.Interface)
+		if !ok {
+			g.errorf("%s: monomorphized non-interface generic types are not yet supported", name)
+			continue
+		}
+		for i := 0; i < origin.NumMethods(); i++ {
+			g.genInterfaceMethodSignature(origin.Method(i), name, header)
+		}
+	}
+	g.curInstantiation = nil
+}
+
 func (_ *generator) toCFlag(v bool) int {
 	if v {
 		return 1
@@ -139,6 +436,22 @@ func (g *generator) errorf(format string, args ...interface{}) {
 	g.err = append(g.err, fmt.Errorf(format, args...))
 }
 
+// cgoTypeMode returns the Cgo type for a value of type t passed or returned
+// with the given mode. It differs from cgoType only for modePinned byte
+// slices, for which it returns "nbyteslice_pinned" instead of
+// "nbyteslice". It only names the type: no code in this package pins
+// the slice or releases it afterwards.
+func (g *generator) cgoTypeMode(t types.Type, mode varMode) string {
+	if mode == modePinned {
+		if s, ok := t.(*types.Slice); ok {
+			if b, ok := s.Elem().(*types.Basic); ok && b.Kind() == types.Uint8 {
+				return "nbyteslice_pinned"
+			}
+		}
+	}
+	return g.cgoType(t)
+}
+
 // cgoType returns the name of a Cgo type suitable for converting a value of
 // the given type.
 func (g *generator) cgoType(t types.Type) string {
@@ -151,6 +464,14 @@ func (g *generator) cgoType(t types.Type) string {
 		case types.Bool, types.UntypedBool:
 			return "char"
 		case types.Int:
+			// nint assumes the host's own int width. A package loaded
+			// from export data for a foreign target needs that width
+			// read back from g.sizes instead.
+			if g.sizes != nil && g.sizes.Sizeof(types.Typ[types.Int]) == 8 {
+				return "int64_t"
+			} else if g.sizes != nil {
+				return "int32_t"
+			}
 			return "nint"
 		case types.Int8:
 			return "int8_t"
@@ -162,7 +483,19 @@ func (g *generator) cgoType(t types.Type) string {
 			return "int64_t"
 		case types.Uint8: // types.Byte
 			return "uint8_t"
-		// TODO(crawshaw): case types.Uint, types.Uint16, types.Uint32, types.Uint64:
+		case types.Uint:
+			if g.sizes != nil && g.sizes.Sizeof(types.Typ[types.Uint]) == 8 {
+				return "uint64_t"
+			} else if g.sizes != nil {
+				return "uint32_t"
+			}
+			return "nuint"
+		case types.Uint16:
+			return "uint16_t"
+		case types.Uint32:
+			return "uint32_t"
+		case types.Uint64:
+			return "uint64_t"
 		case types.Float32:
 			return "float"
 		case types.Float64, types.UntypedFloat:
@@ -191,11 +524,76 @@ func (g *generator) cgoType(t types.Type) string {
 		panic(fmt.Sprintf("unsupported pointer to type: %s", t))
 	case *types.Named:
 		return "int32_t"
+	case *types.TypeParam:
+		// A bare type parameter only makes sense inside a generic
+		// signature that has since been monomorphized: resolve it
+		// against the instantiation we are currently generating code
+		// for and recurse on the substituted type.
+		if inst := g.curInstantiation; inst != nil {
+			if arg := typeArgFor(inst, t); arg != nil {
+				return g.cgoType(arg)
+			}
+		}
+		panic(fmt.Sprintf("unresolved type parameter %s outside of a monomorphized instantiation", t))
 	default:
 		panic(fmt.Sprintf("unsupported type: %s", t))
 	}
 }
 
+// typeArgFor returns the concrete type instantiation binds to tp, or nil if
+// tp does not belong to inst's generic origin.
+func typeArgFor(inst *types.Named, tp *types.TypeParam) types.Type {
+	origin := inst.Origin()
+	params := origin.TypeParams()
+	args := inst.TypeArgs()
+	for i := 0; i < params.Len(); i++ {
+		if params.At(i) == tp {
+			return args.At(i)
+		}
+	}
+	return nil
+}
+
+// mangledName returns the deterministic bound name for a monomorphized
+// generic instantiation, e.g. "Box[int32]" becomes "Box_int32".
+func mangledName(inst *types.Named) string {
+	name := inst.Origin().Obj().Name()
+	args := inst.TypeArgs()
+	for i := 0; i < args.Len(); i++ {
+		name += "_" + mangledTypeName(args.At(i))
+	}
+	return name
+}
+
+// funcInstantiation is a concrete instantiation of an exported generic
+// function, e.g. the right-hand side of "var AddInts = Add[int]".
+type funcInstantiation struct {
+	origin *types.Func
+	name   string
+	sig    *types.Signature
+}
+
+// mangledFuncName returns the deterministic bound name for an
+// instantiation of a generic function, e.g. "Add_int32".
+func mangledFuncName(origin *types.Func, targs *types.TypeList) string {
+	name := origin.Name()
+	for i := 0; i < targs.Len(); i++ {
+		name += "_" + mangledTypeName(targs.At(i))
+	}
+	return name
+}
+
+func mangledTypeName(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Basic:
+		return t.Name()
+	case *types.Named:
+		return t.Obj().Name()
+	default:
+		return strings.NewReplacer(" ", "_", "*", "p", "[]", "s_").Replace(t.String())
+	}
+}
+
 func (g *generator) genInterfaceMethodSignature(m *types.Func, iName string, header bool) {
 	sig := m.Type().(*types.Signature)
 	params := sig.Params()
@@ -222,8 +620,8 @@ func (g *generator) genInterfaceMethodSignature(m *types.Func, iName string, hea
 	}
 	g.Printf("cproxy%s_%s_%s(int32_t refnum", g.pkgPrefix, iName, m.Name())
 	for i := 0; i < params.Len(); i++ {
-		t := params.At(i).Type()
-		g.Printf(", %s %s", g.cgoType(t), paramName(params, i))
+		p := params.At(i)
+		g.Printf(", %s %s", g.cgoTypeMode(p.Type(), g.modeOf(p)), paramName(params, i))
 	}
 	g.Printf(")")
 	if header {